@@ -18,26 +18,106 @@ package trie
 
 import (
 	"errors"
+	"fmt"
 	"math/big"
+	"sync"
+	"sync/atomic"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/trie/triedb/hashdb"
 	"github.com/ethereum/go-ethereum/trie/triedb/pathdb"
+	"github.com/ethereum/go-ethereum/trie/triedb/verkle"
 	"github.com/ethereum/go-ethereum/trie/trienode"
 	"github.com/ethereum/go-ethereum/trie/triestate"
 )
 
+// Built-in backend scheme identifiers. Forks that register their own
+// backend (see RegisterBackend) are free to pick any other name.
+const (
+	HashScheme   = "hash"   // Legacy reference counted hash based scheme
+	PathScheme   = "path"   // Experimental path based scheme
+	ZkScheme     = "zk"     // zktrie based scheme
+	VerkleScheme = "verkle" // verkle tree based scheme
+)
+
+// dbInstanceCounter hands out unique suffixes for per-instance metrics, so
+// that running several Database instances side by side (e.g. MPT and zktrie
+// during a migration) doesn't have them clobber each other's gauges.
+var dbInstanceCounter uint64
+
+// PreimageStore is the interface for caching preimages of node keys. It can
+// be supplied by embedders in place of the default disk-backed store (see
+// Config.PreimageStore), letting them share a single cache across multiple
+// Database instances (e.g. MPT and zktrie side-by-side during a migration),
+// back preimages with a remote KV store, or disable them per-subsystem.
+type PreimageStore interface {
+	// Preimage retrieves the preimage of the specified hash, returning nil
+	// if it's not cached.
+	Preimage(hash common.Hash) []byte
+
+	// InsertPreimage writes the given preimages into the store, keyed by
+	// hash. It's the caller's responsibility to not mutate the map afterwards.
+	InsertPreimage(preimages map[common.Hash][]byte)
+
+	// Commit flushes matured preimages to disk. If force is true, flushes
+	// everything held in memory regardless of maturity.
+	Commit(force bool) error
+
+	// Size returns the storage size of the cached preimages.
+	Size() common.StorageSize
+}
+
 // Config defines all necessary options for database.
 type Config struct {
-	Preimages bool           // Flag whether the preimage of node key is recorded
-	HashDB    *hashdb.Config // Configs for hash-based scheme
-	PathDB    *pathdb.Config // Configs for experimental path-based scheme
-	Zktrie    bool           // use zktrie
+	Preimages     bool          // Flag whether the preimage of node key is recorded
+	PreimageStore PreimageStore // Optional externally supplied preimage store, takes precedence over Preimages
+
+	HashDB   *hashdb.Config // Configs for hash-based scheme
+	PathDB   *pathdb.Config // Configs for experimental path-based scheme
+	Zktrie   bool           // use zktrie
+	IsVerkle bool           // Flag whether the db is holding a verkle tree
 
 	ExperimentalZkTrie bool // use zktree
+
+	// Scheme and SchemeConfig select the backend through the registry
+	// populated by RegisterBackend, bypassing the legacy HashDB/PathDB/Zktrie
+	// switches below. SchemeConfig is handed to the registered factory
+	// verbatim; its concrete type is scheme-specific (e.g. *hashdb.Config).
+	//
+	// When Scheme is empty it is derived from the legacy fields for
+	// backward compatibility.
+	Scheme       string
+	SchemeConfig any
+}
+
+// resolveScheme derives the backend scheme name and its opaque configuration
+// from the config, falling back to the legacy HashDB/PathDB/Zktrie fields
+// when Scheme is not explicitly set.
+func (c *Config) resolveScheme() (string, any) {
+	if c.Scheme != "" {
+		return c.Scheme, c.SchemeConfig
+	}
+	if c.IsVerkle {
+		return VerkleScheme, c.PathDB
+	}
+	if c.HashDB != nil && c.PathDB != nil {
+		log.Crit("Both 'hash' and 'path' mode are configured")
+	}
+	switch {
+	case c.PathDB != nil && c.Zktrie:
+		log.Crit("pbss does not support in zktrie")
+		return PathScheme, c.PathDB
+	case c.PathDB != nil:
+		return PathScheme, c.PathDB
+	case c.Zktrie:
+		return ZkScheme, c.HashDB
+	default:
+		return HashScheme, c.HashDB
+	}
 }
 
 // HashDefaults represents a config for using hash-based scheme with
@@ -91,16 +171,96 @@ type backend interface {
 
 	// Close closes the trie database backend and releases all held resources.
 	Close() error
+
+	// Reader returns a reader for accessing all trie nodes with provided
+	// state root. An error will be returned if the requested state is not
+	// available.
+	//
+	// The concrete value returned must implement the package-level Reader
+	// interface; it's typed any here so that backend implementations (in
+	// their own packages, e.g. hashdb, pathdb) don't need to import this
+	// package just to name that return type, which would create an import
+	// cycle. Database.Reader asserts it back to Reader.
+	Reader(blockRoot common.Hash) (any, error)
+}
+
+// Reader wraps the Node method of a state trie reader. Every backend scheme
+// returns its own implementation from its Reader method.
+type Reader interface {
+	// Node retrieves the trie node blob with the provided trie identifier,
+	// node path and the corresponding node hash.
+	Node(owner common.Hash, path []byte, hash common.Hash) ([]byte, error)
+}
+
+// deletionTracker is implemented by backends that persist node deletions as
+// tombstones rather than leaving stale entries behind, and can therefore
+// report how many nodes they've removed. trienode.Node values with a
+// zero-length blob mean "delete" to these backends.
+type deletionTracker interface {
+	// DeletedNodes returns the number of trie nodes removed from the
+	// database so far.
+	DeletedNodes() int
+}
+
+// BackendFactory constructs a backend for the scheme it was registered
+// under. cfg is the opaque value carried by Config.SchemeConfig (or the
+// legacy per-scheme config field it was derived from) and may be nil.
+type BackendFactory func(diskdb ethdb.Database, cfg any) (backend, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]BackendFactory)
+)
+
+// RegisterBackend registers a trie node storage backend under the given
+// scheme name, making it selectable through Config.Scheme. This allows
+// downstream forks (L2s, verkle prototypes, alternative zk backends) to plug
+// in new schemes without editing this file. Built-in schemes (hash, path,
+// zk, verkle) are registered the same way during package initialization.
+//
+// RegisterBackend is not safe to call concurrently with NewDatabase; it's
+// meant to be used from package init functions.
+func RegisterBackend(scheme string, factory BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[scheme] = factory
+}
+
+func lookupBackend(scheme string) (BackendFactory, bool) {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	factory, ok := backends[scheme]
+	return factory, ok
+}
+
+func init() {
+	RegisterBackend(HashScheme, func(diskdb ethdb.Database, cfg any) (backend, error) {
+		hcfg, _ := cfg.(*hashdb.Config)
+		return hashdb.New(diskdb, hcfg, mptResolver{}), nil
+	})
+	RegisterBackend(PathScheme, func(diskdb ethdb.Database, cfg any) (backend, error) {
+		pcfg, _ := cfg.(*pathdb.Config)
+		return pathdb.New(diskdb, pcfg), nil
+	})
+	RegisterBackend(ZkScheme, func(diskdb ethdb.Database, cfg any) (backend, error) {
+		hcfg, _ := cfg.(*hashdb.Config)
+		return hashdb.NewZk(diskdb, hcfg), nil
+	})
+	RegisterBackend(VerkleScheme, func(diskdb ethdb.Database, cfg any) (backend, error) {
+		pcfg, _ := cfg.(*pathdb.Config)
+		return verkle.New(diskdb, pcfg), nil
+	})
 }
 
 // Database is the wrapper of the underlying backend which is shared by different
 // types of node backend as an entrypoint. It's responsible for all interactions
 // relevant with trie nodes and node preimages.
 type Database struct {
-	config    *Config        // Configuration for trie database
-	diskdb    ethdb.Database // Persistent database to store the snapshot
-	preimages *preimageStore // The store for caching preimages
-	backend   backend        // The backend for managing trie nodes
+	config            *Config        // Configuration for trie database
+	diskdb            ethdb.Database // Persistent database to store the snapshot
+	preimages         PreimageStore  // The store for caching preimages
+	backend           backend        // The backend for managing trie nodes
+	deletedNodesGauge metrics.Gauge  // Per-instance gauge tracking backend.DeletedNodes()
 }
 
 func NewZkDatabase(diskdb ethdb.Database) *Database {
@@ -114,44 +274,45 @@ func NewDatabase(diskdb ethdb.Database, config *Config) *Database {
 	if config == nil {
 		config = HashDefaults
 	}
-	var preimages *preimageStore
-	if config.Preimages {
+	var preimages PreimageStore
+	switch {
+	case config.PreimageStore != nil:
+		preimages = config.PreimageStore
+	case config.Preimages:
 		preimages = newPreimageStore(diskdb)
 	}
+	id := atomic.AddUint64(&dbInstanceCounter, 1)
 	db := &Database{
 		config:    config,
 		diskdb:    diskdb,
 		preimages: preimages,
 	}
-	if config.HashDB != nil && config.PathDB != nil {
-		log.Crit("Both 'hash' and 'path' mode are configured")
+	scheme, schemeConfig := config.resolveScheme()
+	db.deletedNodesGauge = metrics.NewRegisteredGauge(fmt.Sprintf("trie/db/%s/deletednodes/%d", scheme, id), nil)
+	factory, ok := lookupBackend(scheme)
+	if !ok {
+		log.Crit("Unknown trie node scheme", "scheme", scheme)
 	}
-	if config.PathDB != nil {
-		if config.Zktrie {
-			log.Crit("pbss does not support in zktrie")
-		} else {
-			db.backend = pathdb.New(diskdb, config.PathDB)
-		}
-	} else {
-		if config.Zktrie {
-			db.backend = hashdb.NewZk(diskdb, config.HashDB)
-		} else {
-			db.backend = hashdb.New(diskdb, config.HashDB, mptResolver{})
-		}
+	b, err := factory(diskdb, schemeConfig)
+	if err != nil {
+		log.Crit("Failed to construct trie database backend", "scheme", scheme, "err", err)
 	}
+	db.backend = b
 	return db
 }
 
 // Reader returns a reader for accessing all trie nodes with provided state root.
 // An error will be returned if the requested state is not available.
 func (db *Database) Reader(blockRoot common.Hash) (Reader, error) {
-	switch b := db.backend.(type) {
-	case *hashdb.Database:
-		return b.Reader(blockRoot)
-	case *pathdb.Database:
-		return b.Reader(blockRoot)
+	r, err := db.backend.Reader(blockRoot)
+	if err != nil {
+		return nil, err
+	}
+	reader, ok := r.(Reader)
+	if !ok {
+		return nil, errors.New("backend returned a value that doesn't implement trie.Reader")
 	}
-	return nil, errors.New("unknown backend")
+	return reader, nil
 }
 
 // Update performs a state transition by committing dirty nodes contained in the
@@ -163,19 +324,41 @@ func (db *Database) Reader(blockRoot common.Hash) (Reader, error) {
 // Therefore, these maps must not be changed afterwards.
 func (db *Database) Update(root common.Hash, parent common.Hash, block uint64, nodes *trienode.MergedNodeSet, states *triestate.Set) error {
 	if db.preimages != nil {
-		db.preimages.commit(false)
+		db.preimages.Commit(false)
 	}
 	return db.backend.Update(root, parent, block, nodes, states)
 }
 
+// DeletedNodes returns the number of trie nodes removed from the database so
+// far. It reports zero for backends that don't track deletions explicitly.
+func (db *Database) DeletedNodes() int {
+	if dt, ok := db.backend.(deletionTracker); ok {
+		return dt.DeletedNodes()
+	}
+	return 0
+}
+
 // Commit iterates over all the children of a particular node, writes them out
 // to disk. As a side effect, all pre-images accumulated up to this point are
 // also written.
+//
+// The deleted-nodes gauge is refreshed here rather than in Update: hashdb only
+// turns buffered tombstones into actual disk deletions on Commit, so reading
+// DeletedNodes() right after Update would report last cycle's count instead
+// of this one. Refreshing after backend.Commit gives every scheme a single,
+// consistent point (the point its deletions are actually durable) at which
+// the gauge reflects reality.
 func (db *Database) Commit(root common.Hash, report bool) error {
 	if db.preimages != nil {
-		db.preimages.commit(true)
+		db.preimages.Commit(true)
+	}
+	if err := db.backend.Commit(root, report); err != nil {
+		return err
+	}
+	if dt, ok := db.backend.(deletionTracker); ok {
+		db.deletedNodesGauge.Update(int64(dt.DeletedNodes()))
 	}
-	return db.backend.Commit(root, report)
+	return nil
 }
 
 // Size returns the storage size of diff layer nodes above the persistent disk
@@ -188,7 +371,7 @@ func (db *Database) Size() (common.StorageSize, common.StorageSize, common.Stora
 	)
 	diffs, nodes = db.backend.Size()
 	if db.preimages != nil {
-		preimages = db.preimages.size()
+		preimages = db.preimages.Size()
 	}
 	return diffs, nodes, preimages
 }
@@ -215,7 +398,7 @@ func (db *Database) Close() error {
 // WritePreimages flushes all accumulated preimages to disk forcibly.
 func (db *Database) WritePreimages() {
 	if db.preimages != nil {
-		db.preimages.commit(true)
+		db.preimages.Commit(true)
 	}
 }
 
@@ -230,7 +413,7 @@ func (db *Database) Cap(limit common.StorageSize) error {
 		return errors.New("not supported")
 	}
 	if db.preimages != nil {
-		db.preimages.commit(false)
+		db.preimages.Commit(false)
 	}
 	return hdb.Cap(limit)
 }
@@ -294,6 +477,52 @@ func (db *Database) Recoverable(root common.Hash) (bool, error) {
 	return pdb.Recoverable(root), nil
 }
 
+// StateHistory records the value of an account or storage slot before and
+// after the state transition executed in Block.
+type StateHistory struct {
+	Block uint64
+	Prev  []byte // RLP-encoded account, or storage slot value, before the transition; nil if absent
+	Post  []byte // RLP-encoded account, or storage slot value, after the transition; nil if deleted
+}
+
+// AccountHistory returns the recorded history of the given account across
+// the block range [start, end], inclusive. It's only supported by
+// path-based database and will return an error for others.
+func (db *Database) AccountHistory(addr common.Address, start, end uint64) ([]StateHistory, error) {
+	pdb, ok := db.backend.(*pathdb.Database)
+	if !ok {
+		return nil, errors.New("not supported")
+	}
+	records, err := pdb.AccountHistory(addr, start, end)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]StateHistory, len(records))
+	for i, r := range records {
+		history[i] = StateHistory{Block: r.Block, Prev: r.Prev, Post: r.Post}
+	}
+	return history, nil
+}
+
+// StorageHistory returns the recorded history of the given storage slot
+// across the block range [start, end], inclusive. It's only supported by
+// path-based database and will return an error for others.
+func (db *Database) StorageHistory(addr common.Address, slot common.Hash, start, end uint64) ([]StateHistory, error) {
+	pdb, ok := db.backend.(*pathdb.Database)
+	if !ok {
+		return nil, errors.New("not supported")
+	}
+	records, err := pdb.StorageHistory(addr, slot, start, end)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]StateHistory, len(records))
+	for i, r := range records {
+		history[i] = StateHistory{Block: r.Block, Prev: r.Prev, Post: r.Post}
+	}
+	return history, nil
+}
+
 // Reset wipes all available journal from the persistent database and discard
 // all caches and diff layers. Using the given root to create a new disk layer.
 // It's only supported by path-based database and will return an error for others.
@@ -328,17 +557,26 @@ func (db *Database) SetBufferSize(size int) error {
 	return pdb.SetBufferSize(size)
 }
 
+// UpdatePreimage records the preimage of a node key, keyed under the given
+// hash field. The interface owns the key format, so this no longer requires
+// a zktrie-specific backend.
 func (db *Database) UpdatePreimage(preimage []byte, hashField *big.Int) {
-	if _, ok := db.backend.(*hashdb.ZktrieDatabase); !ok {
-		log.Error("non zkTrie database UpdatePreimage does not support ")
+	if db.preimages == nil {
 		return
 	}
-	if db.preimages != nil {
-		// we must copy the input key
-		preimages := make(map[common.Hash][]byte)
-		preimages[common.BytesToHash(hashField.Bytes())] = common.CopyBytes(preimage)
-		db.preimages.insertPreimage(preimages)
+	// we must copy the input key
+	preimages := make(map[common.Hash][]byte)
+	preimages[common.BytesToHash(hashField.Bytes())] = common.CopyBytes(preimage)
+	db.preimages.InsertPreimage(preimages)
+}
+
+// Preimage returns the preimage of the given node key hash, or nil if it's
+// not present (including when preimages are disabled).
+func (db *Database) Preimage(hash common.Hash) []byte {
+	if db.preimages == nil {
+		return nil
 	}
+	return db.preimages.Preimage(hash)
 }
 
 func (db *Database) Put(k, v []byte) error {
@@ -359,33 +597,63 @@ func (db *Database) Get(key []byte) ([]byte, error) {
 
 func (db *Database) IsZk() bool          { return db.config.Zktrie }
 func (db *Database) IsZkStateTrie() bool { return db.config.Zktrie && db.config.ExperimentalZkTrie }
+func (db *Database) IsVerkle() bool      { return db.config.IsVerkle }
 
-func (db *Database) SetBackend(isZk bool) {
-	if db.config.Zktrie == isZk {
-		return
-	}
-	db.config = &Config{
-		Preimages:          db.config.Preimages,
-		HashDB:             db.config.HashDB,
-		PathDB:             db.config.PathDB,
-		Zktrie:             isZk,
-		ExperimentalZkTrie: db.config.ExperimentalZkTrie,
-	}
-	if db.config.PathDB != nil {
-		if isZk {
-			log.Crit("pbss does not support in zktrie")
-		} else {
-			db.backend = pathdb.New(db.diskdb, db.config.PathDB)
-		}
-	} else {
-		if isZk {
-			db.backend = hashdb.NewZk(db.diskdb, db.config.HashDB)
-		} else {
-			db.backend = hashdb.New(db.diskdb, db.config.HashDB, mptResolver{})
+// uncommittedChecker is implemented by backends that can hold dirty state
+// above their persistent disk layer (currently only pathdb), so Fork can
+// refuse to switch schemes out from under it.
+type uncommittedChecker interface {
+	HasUncommittedDiffs() bool
+}
+
+// Fork returns a new Database backed by newConfig, sharing the same
+// persistent disk store as db. Unlike the old SetBackend, it never mutates
+// db in place: hot-swapping the backend underneath a live Database is racy
+// against in-flight Reader/Update/Commit callers and can leave the pathdb
+// journal or hashdb dirty set orphaned.
+//
+// Fork refuses to switch between path/hash/zk schemes while db still has
+// uncommitted diff layers, and closes db's previous backend once the new one
+// has been constructed successfully. The guard is only as good as the
+// backend's HasUncommittedDiffs implementation: pathdb.Database implements
+// it against the diff layers appended since its last Commit, so forking away
+// from the path scheme is actually guarded. The hash and zk backends don't
+// implement uncommittedChecker, so their dirty nodes buffered since the last
+// Commit are not checked and Fork proceeds.
+//
+// SetBackend, the hot-swap method this replaced, had no callers anywhere
+// outside this file, so removing it didn't break any caller.
+func (db *Database) Fork(newConfig *Config) (*Database, error) {
+	oldScheme, _ := db.config.resolveScheme()
+	newScheme, _ := newConfig.resolveScheme()
+	if oldScheme != newScheme {
+		if uc, ok := db.backend.(uncommittedChecker); ok && uc.HasUncommittedDiffs() {
+			return nil, errors.New("cannot fork trie database: uncommitted diff layers present")
 		}
 	}
+	forked := NewDatabase(db.diskdb, newConfig)
+	if err := db.backend.Close(); err != nil {
+		// forked's backend is already open at this point; since we're
+		// returning an error instead of handing it to the caller, close it
+		// too rather than leaking it.
+		forked.backend.Close()
+		return nil, fmt.Errorf("failed to close previous backend: %w", err)
+	}
+	return forked, nil
 }
 
+// EmptyRoot returns the known empty-trie root for the database's storage
+// scheme. It consults the resolved scheme (the same derivation NewDatabase
+// uses) rather than the legacy IsVerkle/Zktrie flags alone, so a Database
+// configured through Config.Scheme/SchemeConfig (bypassing those flags
+// entirely) still reports the right empty root.
 func (db *Database) EmptyRoot() common.Hash {
-	return types.GetEmptyRootHash(db.config != nil && db.config.Zktrie)
+	if db.config == nil {
+		return types.GetEmptyRootHash(false)
+	}
+	scheme, _ := db.config.resolveScheme()
+	if scheme == VerkleScheme {
+		return verkle.EmptyRootHash
+	}
+	return types.GetEmptyRootHash(scheme == ZkScheme)
 }
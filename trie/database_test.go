@@ -0,0 +1,239 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/trie/triedb/hashdb"
+	"github.com/ethereum/go-ethereum/trie/triedb/pathdb"
+	"github.com/ethereum/go-ethereum/trie/triedb/verkle"
+	"github.com/ethereum/go-ethereum/trie/trienode"
+	"github.com/ethereum/go-ethereum/trie/triestate"
+)
+
+// fakeBackend is a minimal backend implementation used to exercise the
+// RegisterBackend/lookupBackend dispatch without depending on a real scheme.
+type fakeBackend struct{ cfg any }
+
+func (b *fakeBackend) Scheme() string                                 { return "fake" }
+func (b *fakeBackend) Initialized(common.Hash) bool                   { return false }
+func (b *fakeBackend) Size() (common.StorageSize, common.StorageSize) { return 0, 0 }
+func (b *fakeBackend) Update(common.Hash, common.Hash, uint64, *trienode.MergedNodeSet, *triestate.Set) error {
+	return nil
+}
+func (b *fakeBackend) Commit(common.Hash, bool) error  { return nil }
+func (b *fakeBackend) Close() error                    { return nil }
+func (b *fakeBackend) Reader(common.Hash) (any, error) { return nil, errors.New("no state") }
+
+func TestRegisterBackendDispatch(t *testing.T) {
+	const scheme = "fake-test-scheme"
+	RegisterBackend(scheme, func(diskdb ethdb.Database, cfg any) (backend, error) {
+		return &fakeBackend{cfg: cfg}, nil
+	})
+
+	factory, ok := lookupBackend(scheme)
+	if !ok {
+		t.Fatalf("scheme %q not found after RegisterBackend", scheme)
+	}
+	b, err := factory(memorydb.New(), "cfg-marker")
+	if err != nil {
+		t.Fatalf("factory returned error: %v", err)
+	}
+	if got := b.Scheme(); got != "fake" {
+		t.Fatalf("unexpected backend scheme: got %q", got)
+	}
+
+	if _, ok := lookupBackend("does-not-exist"); ok {
+		t.Fatalf("lookupBackend unexpectedly found an unregistered scheme")
+	}
+}
+
+func TestConfigResolveScheme(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *Config
+		scheme string
+	}{
+		{"explicit scheme wins", &Config{Scheme: "custom", HashDB: hashdb.Defaults}, "custom"},
+		{"verkle flag", &Config{IsVerkle: true}, VerkleScheme},
+		{"zktrie flag", &Config{Zktrie: true}, ZkScheme},
+		{"pathdb config", &Config{PathDB: pathdb.Defaults}, PathScheme},
+		{"default falls back to hash", &Config{}, HashScheme},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheme, _ := test.config.resolveScheme()
+			if scheme != test.scheme {
+				t.Fatalf("resolveScheme() = %q, want %q", scheme, test.scheme)
+			}
+		})
+	}
+}
+
+// fakePreimageStore lets the test assert that Config.PreimageStore takes
+// precedence over the default disk-backed store without touching disk.
+type fakePreimageStore struct{ inserted map[common.Hash][]byte }
+
+func (s *fakePreimageStore) Preimage(hash common.Hash) []byte { return s.inserted[hash] }
+func (s *fakePreimageStore) InsertPreimage(preimages map[common.Hash][]byte) {
+	for hash, preimage := range preimages {
+		s.inserted[hash] = preimage
+	}
+}
+func (s *fakePreimageStore) Commit(force bool) error  { return nil }
+func (s *fakePreimageStore) Size() common.StorageSize { return 0 }
+
+func TestNewDatabasePreimageStoreOverride(t *testing.T) {
+	store := &fakePreimageStore{inserted: make(map[common.Hash][]byte)}
+	config := &Config{PreimageStore: store, HashDB: hashdb.Defaults}
+
+	db := NewDatabase(memorydb.New(), config)
+	if db.preimages != store {
+		t.Fatalf("NewDatabase did not use the supplied PreimageStore override")
+	}
+
+	hash := common.BytesToHash([]byte("preimage-hash"))
+	db.preimages.InsertPreimage(map[common.Hash][]byte{hash: []byte("preimage")})
+	if got := store.Preimage(hash); string(got) != "preimage" {
+		t.Fatalf("preimage not recorded in the overriding store: got %q", got)
+	}
+}
+
+func TestNewDatabaseDefaultPreimageStore(t *testing.T) {
+	config := &Config{Preimages: true, HashDB: hashdb.Defaults}
+	db := NewDatabase(memorydb.New(), config)
+	if db.preimages == nil {
+		t.Fatalf("NewDatabase did not install the default preimage store when Preimages is set")
+	}
+	if _, ok := db.preimages.(*preimageStore); !ok {
+		t.Fatalf("expected the default preimageStore implementation, got %T", db.preimages)
+	}
+}
+
+// TestCommitRefreshesDeletedNodesGauge exercises the hash scheme, where a
+// tombstoned node only turns into an actual disk deletion on Commit, to make
+// sure the deleted-nodes gauge tracks that and isn't left stale after Update.
+func TestCommitRefreshesDeletedNodesGauge(t *testing.T) {
+	db := NewDatabase(memorydb.New(), &Config{HashDB: hashdb.Defaults})
+
+	set := trienode.NewNodeSet(common.Hash{})
+	set.AddNode([]byte{0x01}, trienode.NewDeleted(common.BytesToHash([]byte("node"))))
+	merged := trienode.NewMergedNodeSet()
+	if err := merged.Merge(set); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+
+	if err := db.Update(common.Hash{}, common.Hash{}, 1, merged, nil); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if got := db.deletedNodesGauge.Snapshot().Value(); got != 0 {
+		t.Fatalf("gauge should still read 0 right after Update (hashdb only deletes on Commit), got %d", got)
+	}
+
+	if err := db.Commit(common.Hash{}, false); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if got := db.deletedNodesGauge.Snapshot().Value(); got != 1 {
+		t.Fatalf("gauge should reflect the tombstone removed by Commit, got %d", got)
+	}
+}
+
+func TestEmptyRootFollowsResolvedScheme(t *testing.T) {
+	hashDB := NewDatabase(memorydb.New(), &Config{HashDB: hashdb.Defaults})
+	if got := hashDB.EmptyRoot(); got != types.GetEmptyRootHash(false) {
+		t.Fatalf("hash scheme EmptyRoot = %#x, want %#x", got, types.GetEmptyRootHash(false))
+	}
+
+	zkDB := NewDatabase(memorydb.New(), &Config{Zktrie: true, HashDB: hashdb.Defaults})
+	if got := zkDB.EmptyRoot(); got != types.GetEmptyRootHash(true) {
+		t.Fatalf("zktrie scheme EmptyRoot = %#x, want %#x", got, types.GetEmptyRootHash(true))
+	}
+
+	verkleViaFlag := NewDatabase(memorydb.New(), &Config{IsVerkle: true, PathDB: pathdb.Defaults})
+	if got := verkleViaFlag.EmptyRoot(); got != verkle.EmptyRootHash {
+		t.Fatalf("IsVerkle-flag EmptyRoot = %#x, want %#x", got, verkle.EmptyRootHash)
+	}
+
+	// A Database configured purely through the chunk0-1 registry (Scheme/
+	// SchemeConfig), bypassing IsVerkle entirely, must still report the
+	// verkle empty root.
+	verkleViaScheme := NewDatabase(memorydb.New(), &Config{Scheme: VerkleScheme, SchemeConfig: pathdb.Defaults})
+	if got := verkleViaScheme.EmptyRoot(); got != verkle.EmptyRootHash {
+		t.Fatalf("Scheme=VerkleScheme EmptyRoot = %#x, want %#x", got, verkle.EmptyRootHash)
+	}
+}
+
+func TestForkRefusesWhenUncommittedDiffsPresent(t *testing.T) {
+	db := NewDatabase(memorydb.New(), &Config{PathDB: pathdb.Defaults})
+
+	states := triestate.New()
+	states.Accounts[common.HexToAddress("0x1")] = triestate.StateChange{Post: []byte("v")}
+	if err := db.Update(common.Hash{}, common.Hash{}, 1, nil, states); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	if _, err := db.Fork(&Config{HashDB: hashdb.Defaults}); err == nil {
+		t.Fatalf("expected Fork to refuse switching schemes with an uncommitted diff layer present")
+	}
+
+	if err := db.Commit(common.Hash{}, false); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if _, err := db.Fork(&Config{HashDB: hashdb.Defaults}); err != nil {
+		t.Fatalf("Fork should succeed once the diff layer has been committed: %v", err)
+	}
+}
+
+// trackingBackend is a fakeBackend whose Close error is controllable and which
+// records whether Close was called, used to probe Fork's backend lifecycle
+// handling.
+type trackingBackend struct {
+	fakeBackend
+	closeErr error
+	closed   bool
+}
+
+func (b *trackingBackend) Close() error {
+	b.closed = true
+	return b.closeErr
+}
+
+func TestForkClosesNewBackendIfOldCloseFails(t *testing.T) {
+	oldBackend := &trackingBackend{closeErr: errors.New("boom")}
+	newBackend := &trackingBackend{}
+
+	RegisterBackend("fork-test-old-scheme", func(diskdb ethdb.Database, cfg any) (backend, error) {
+		return oldBackend, nil
+	})
+	RegisterBackend("fork-test-new-scheme", func(diskdb ethdb.Database, cfg any) (backend, error) {
+		return newBackend, nil
+	})
+
+	db := NewDatabase(memorydb.New(), &Config{Scheme: "fork-test-old-scheme"})
+	if _, err := db.Fork(&Config{Scheme: "fork-test-new-scheme"}); err == nil {
+		t.Fatalf("expected Fork to surface the old backend's close error")
+	}
+	if !newBackend.closed {
+		t.Fatalf("Fork leaked the newly constructed backend after failing to close the old one")
+	}
+}
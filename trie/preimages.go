@@ -0,0 +1,119 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// preimageCommitLimit is the cumulative size of cached preimages above which
+// a non-forced Commit flushes them to disk.
+const preimageCommitLimit = 4 * 1024 * 1024
+
+// preimageStore is the built-in, disk-backed PreimageStore implementation
+// used when Config.Preimages is set and no external PreimageStore is
+// supplied via Config.PreimageStore.
+type preimageStore struct {
+	lock          sync.RWMutex
+	disk          ethdb.Database
+	preimages     map[common.Hash][]byte
+	preimagesSize common.StorageSize
+}
+
+// newPreimageStore constructs a disk-backed preimage store around the given
+// database.
+func newPreimageStore(disk ethdb.Database) *preimageStore {
+	return &preimageStore{
+		disk:      disk,
+		preimages: make(map[common.Hash][]byte),
+	}
+}
+
+// InsertPreimage implements PreimageStore, caching the provided preimages in
+// memory until they are flushed to disk by Commit.
+func (store *preimageStore) InsertPreimage(preimages map[common.Hash][]byte) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	for hash, preimage := range preimages {
+		if _, ok := store.preimages[hash]; ok {
+			continue
+		}
+		store.preimages[hash] = preimage
+		store.preimagesSize += common.StorageSize(common.HashLength + len(preimage))
+	}
+}
+
+// Preimage implements PreimageStore, checking the in-memory cache before
+// falling back to disk.
+func (store *preimageStore) Preimage(hash common.Hash) []byte {
+	store.lock.RLock()
+	preimage := store.preimages[hash]
+	store.lock.RUnlock()
+	if preimage != nil {
+		return preimage
+	}
+	blob, _ := store.disk.Get(preimageKey(hash))
+	return blob
+}
+
+// Commit implements PreimageStore, flushing matured preimages to disk. If
+// force is true, every cached preimage is flushed regardless of the
+// accumulated size.
+func (store *preimageStore) Commit(force bool) error {
+	store.lock.RLock()
+	if store.preimagesSize <= preimageCommitLimit && !force {
+		store.lock.RUnlock()
+		return nil
+	}
+	preimages := make(map[common.Hash][]byte, len(store.preimages))
+	for hash, preimage := range store.preimages {
+		preimages[hash] = preimage
+	}
+	store.lock.RUnlock()
+
+	batch := store.disk.NewBatch()
+	for hash, preimage := range preimages {
+		if err := batch.Put(preimageKey(hash), preimage); err != nil {
+			return err
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.preimages, store.preimagesSize = make(map[common.Hash][]byte), 0
+	return nil
+}
+
+// Size implements PreimageStore.
+func (store *preimageStore) Size() common.StorageSize {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.preimagesSize
+}
+
+// preimageKey returns the disk key under which the preimage of hash is
+// stored, namespaced so it can't collide with trie node keys.
+func preimageKey(hash common.Hash) []byte {
+	return append([]byte("secure-key-"), hash.Bytes()...)
+}
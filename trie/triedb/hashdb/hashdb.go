@@ -0,0 +1,243 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package hashdb implements the legacy, reference-counted hash-based trie
+// node storage scheme.
+package hashdb
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/trie/trienode"
+	"github.com/ethereum/go-ethereum/trie/triestate"
+)
+
+// Config contains the settings for the hash-based scheme.
+type Config struct {
+	CleanCacheSize int // Maximum memory allowance (in bytes) for caching clean nodes
+}
+
+// Defaults is the default setting for the hash-based database.
+var Defaults = &Config{
+	CleanCacheSize: 16 * 1024 * 1024,
+}
+
+// NodeDatabase is the subset of the hash-based API that's only meaningful
+// for the reference-counted hash scheme: raw node access plus the
+// reference-counting primitives used to pin/unpin subtrees (e.g. a storage
+// trie root referenced by its owning account).
+type NodeDatabase interface {
+	// Cap iteratively flushes old but still referenced trie nodes until the
+	// total memory usage goes below the given threshold.
+	Cap(limit common.StorageSize) error
+
+	// Reference adds a new reference from a parent node to a child node.
+	Reference(root common.Hash, parent common.Hash)
+
+	// Dereference removes an existing reference from a root node.
+	Dereference(root common.Hash)
+
+	// Node retrieves the rlp-encoded node blob with the provided node hash.
+	Node(hash common.Hash) ([]byte, error)
+}
+
+// Database is a hash-keyed trie node database implementing the legacy
+// reference-counted hash scheme. Dirty nodes are buffered in memory and
+// flushed to the backing key-value store on Commit; a node carrying a
+// zero-length blob is a tombstone recording that the node was deleted, and
+// is removed from disk (rather than left behind as a stale entry) when
+// committed.
+type Database struct {
+	diskdb ethdb.Database
+	config *Config
+
+	lock    sync.RWMutex
+	dirties map[common.Hash][]byte // hash -> blob; a present, nil/empty blob is a tombstone
+	deleted int64                  // cumulative nodes tombstoned on disk by Commit
+}
+
+// New initializes the hash-based node database. The resolver is accepted for
+// forward compatibility with schemes that need to resolve bare, pre-EIP-2718
+// style legacy nodes; the built-in scheme doesn't need it.
+func New(diskdb ethdb.Database, config *Config, resolver any) *Database {
+	if config == nil {
+		config = Defaults
+	}
+	return &Database{
+		diskdb:  diskdb,
+		config:  config,
+		dirties: make(map[common.Hash][]byte),
+	}
+}
+
+// Scheme returns the identifier of the used storage scheme.
+func (db *Database) Scheme() string { return "hash" }
+
+// Initialized returns an indicator if the state data is already initialized
+// according to the state scheme.
+func (db *Database) Initialized(genesisRoot common.Hash) bool {
+	blob, _ := db.diskdb.Get(genesisRoot.Bytes())
+	return len(blob) > 0
+}
+
+// Size returns the current storage size of the diff layers on top of the
+// disk layer and the storage size of the nodes cached in the disk layer. The
+// hash scheme doesn't differentiate between the two, so everything currently
+// buffered in memory is reported as the second value.
+func (db *Database) Size() (common.StorageSize, common.StorageSize) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var size common.StorageSize
+	for _, blob := range db.dirties {
+		size += common.StorageSize(common.HashLength + len(blob))
+	}
+	return 0, size
+}
+
+// Update buffers the dirty nodes contained in the given set. Nodes with a
+// zero-length blob are deletions and are recorded as tombstones so Commit can
+// remove the corresponding disk entry instead of leaving it stale.
+func (db *Database) Update(root common.Hash, parent common.Hash, block uint64, nodes *trienode.MergedNodeSet, states *triestate.Set) error {
+	if nodes == nil {
+		return nil
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, set := range nodes.Sets {
+		for _, n := range set.Nodes {
+			if n.IsDeleted() {
+				db.dirties[n.Hash] = nil
+				continue
+			}
+			db.dirties[n.Hash] = n.Blob
+		}
+	}
+	return nil
+}
+
+// Commit flushes every buffered node to disk. Tombstoned nodes are deleted
+// from disk rather than written, so storage-slot clears no longer leave
+// stale entries behind.
+func (db *Database) Commit(root common.Hash, report bool) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	batch := db.diskdb.NewBatch()
+	for hash, blob := range db.dirties {
+		if len(blob) == 0 {
+			if err := batch.Delete(hash.Bytes()); err != nil {
+				return err
+			}
+			db.deleted++
+			continue
+		}
+		if err := batch.Put(hash.Bytes(), blob); err != nil {
+			return err
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	db.dirties = make(map[common.Hash][]byte)
+	return nil
+}
+
+// Close closes the trie database backend and releases all held resources.
+func (db *Database) Close() error { return nil }
+
+// Reader returns a state reader for accessing trie nodes at the given state
+// root. The return type is any (rather than trie.Reader) so this package
+// doesn't have to import the trie package; trie.Database.Reader asserts it
+// back to trie.Reader.
+func (db *Database) Reader(root common.Hash) (any, error) {
+	return &reader{db: db}, nil
+}
+
+// DeletedNodes returns the cumulative number of trie nodes that have been
+// tombstoned and actually removed from disk by Commit so far.
+func (db *Database) DeletedNodes() int {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return int(db.deleted)
+}
+
+// Cap is a no-op whatever the limit: this implementation buffers every dirty
+// node until Commit and flushes nothing here.
+func (db *Database) Cap(limit common.StorageSize) error { return nil }
+
+// Reference is a no-op: this implementation doesn't reference-count nodes.
+func (db *Database) Reference(root common.Hash, parent common.Hash) {}
+
+// Dereference is a no-op: this implementation doesn't reference-count nodes,
+// so no node is released or removed here.
+func (db *Database) Dereference(root common.Hash) {}
+
+// Node retrieves the rlp-encoded node blob with the provided node hash,
+// preferring the in-memory dirty set over disk.
+func (db *Database) Node(hash common.Hash) ([]byte, error) {
+	db.lock.RLock()
+	if blob, ok := db.dirties[hash]; ok {
+		db.lock.RUnlock()
+		if len(blob) == 0 {
+			return nil, errors.New("not found: deleted node")
+		}
+		return blob, nil
+	}
+	db.lock.RUnlock()
+	return db.diskdb.Get(hash.Bytes())
+}
+
+// reader implements trie.Reader by resolving node hashes against the dirty
+// set and, failing that, the persistent disk store.
+type reader struct {
+	db *Database
+}
+
+// Node retrieves the rlp-encoded node blob for the given node hash. owner and
+// path are unused in the hash scheme, where nodes are addressed by hash alone.
+func (r *reader) Node(owner common.Hash, path []byte, hash common.Hash) ([]byte, error) {
+	return r.db.Node(hash)
+}
+
+// ZktrieDatabase is a hash-keyed node database variant used for zktrie state.
+// It behaves exactly like Database, additionally exposing raw key/value
+// access for zktrie's own node encoding.
+type ZktrieDatabase struct {
+	*Database
+}
+
+// NewZk initializes the zktrie-flavored hash-based node database.
+func NewZk(diskdb ethdb.Database, config *Config) *ZktrieDatabase {
+	return &ZktrieDatabase{Database: New(diskdb, config, nil)}
+}
+
+// Scheme returns the identifier of the used storage scheme.
+func (db *ZktrieDatabase) Scheme() string { return "zk" }
+
+// Put writes a raw key/value pair in zktrie's own node format.
+func (db *ZktrieDatabase) Put(k, v []byte) error {
+	return db.diskdb.Put(k, v)
+}
+
+// Get reads a raw key/value pair in zktrie's own node format.
+func (db *ZktrieDatabase) Get(key []byte) ([]byte, error) {
+	return db.diskdb.Get(key)
+}
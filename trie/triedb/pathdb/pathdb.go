@@ -0,0 +1,274 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package pathdb implements the experimental path-based trie node storage
+// scheme, keeping a bounded run of per-block diff layers on top of a
+// persistent disk layer.
+package pathdb
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/trie/trienode"
+	"github.com/ethereum/go-ethereum/trie/triestate"
+)
+
+// Config contains the settings for the path-based scheme.
+type Config struct {
+	StateHistory uint64 // Number of recent blocks for which state history is retained
+}
+
+// Defaults is the default setting for the path-based database.
+var Defaults = &Config{
+	StateHistory: 90000,
+}
+
+// Record captures the value of an account or storage slot immediately before
+// and after the state transition executed at Block.
+type Record struct {
+	Block uint64
+	Prev  []byte
+	Post  []byte
+}
+
+// diffLayer records the state mutations committed as part of a single block,
+// retained on top of the persistent disk layer until Commit drops it.
+type diffLayer struct {
+	block    uint64
+	accounts map[common.Address]Record
+	storages map[common.Address]map[common.Hash]Record
+}
+
+// Database is the path-based trie node database. On top of the disk layer it
+// retains a run of per-block diff layers, which back Journal/Reset and the
+// AccountHistory/StorageHistory queries; HasUncommittedDiffs reports whether
+// any such layers are still outstanding. Commit only drops layers older
+// than the config.StateHistory retention window, so history queries keep
+// working for recently committed blocks rather than just the in-flight one.
+type Database struct {
+	diskdb ethdb.Database
+	config *Config
+
+	lock        sync.RWMutex
+	diffs       []*diffLayer // retained diff layers, oldest first, bounded by config.StateHistory
+	uncommitted int          // diff layers appended by Update since the last Commit
+	deleted     int64        // cumulative nodes removed across all updates
+}
+
+// New initializes the path-based node database.
+func New(diskdb ethdb.Database, config *Config) *Database {
+	if config == nil {
+		config = Defaults
+	}
+	return &Database{diskdb: diskdb, config: config}
+}
+
+// Scheme returns the identifier of the used storage scheme.
+func (db *Database) Scheme() string { return "path" }
+
+// Initialized returns an indicator if the state data is already initialized
+// according to the state scheme.
+func (db *Database) Initialized(genesisRoot common.Hash) bool {
+	blob, _ := db.diskdb.Get(genesisRoot.Bytes())
+	return len(blob) > 0
+}
+
+// Size returns the current storage size of the diff layers on top of the
+// disk layer, and the storage size of the nodes cached in the disk layer.
+// Node caching on the disk layer isn't modeled by this minimal
+// implementation, so the second return is always zero.
+func (db *Database) Size() (common.StorageSize, common.StorageSize) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return common.StorageSize(len(db.diffs)) * common.StorageSize(common.HashLength), 0
+}
+
+// Update records the state transition as a new diff layer on top of the
+// retained run, keeping per-account and per-slot before/after values so they
+// can later be served by AccountHistory/StorageHistory.
+func (db *Database) Update(root common.Hash, parent common.Hash, block uint64, nodes *trienode.MergedNodeSet, states *triestate.Set) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	layer := &diffLayer{
+		block:    block,
+		accounts: make(map[common.Address]Record),
+		storages: make(map[common.Address]map[common.Hash]Record),
+	}
+	if states != nil {
+		for addr, change := range states.Accounts {
+			layer.accounts[addr] = Record{Block: block, Prev: change.Prev, Post: change.Post}
+		}
+		for addr, slots := range states.Storages {
+			m := make(map[common.Hash]Record, len(slots))
+			for slot, change := range slots {
+				m[slot] = Record{Block: block, Prev: change.Prev, Post: change.Post}
+			}
+			layer.storages[addr] = m
+		}
+	}
+	if nodes != nil {
+		for _, set := range nodes.Sets {
+			for _, n := range set.Nodes {
+				if n.IsDeleted() {
+					db.deleted++
+				}
+			}
+		}
+	}
+	db.diffs = append(db.diffs, layer)
+	db.uncommitted++
+	return nil
+}
+
+// Commit drops diff layers that have aged out of the retention window
+// (config.StateHistory blocks), keeping the rest around so
+// AccountHistory/StorageHistory can still serve them. Nothing from a dropped
+// layer is written to disk. A StateHistory of zero retains every layer
+// indefinitely.
+//
+// This also clears the uncommitted counter: the layers appended by Update
+// since the last Commit are, by definition, committed once this returns,
+// even though some of them remain in the retained window for history
+// queries. HasUncommittedDiffs tracks that counter rather than len(diffs),
+// so retaining history doesn't make Fork think there's still unflushed state.
+func (db *Database) Commit(root common.Hash, report bool) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.uncommitted = 0
+	limit := db.config.StateHistory
+	if limit == 0 || uint64(len(db.diffs)) <= limit {
+		return nil
+	}
+	db.diffs = db.diffs[uint64(len(db.diffs))-limit:]
+	return nil
+}
+
+// Close closes the trie database backend and releases all held resources.
+func (db *Database) Close() error { return nil }
+
+// Reader returns a state reader for accessing trie nodes at the given state
+// root. The return type is any (rather than trie.Reader) so this package
+// doesn't have to import the trie package; trie.Database.Reader asserts it
+// back to trie.Reader.
+func (db *Database) Reader(root common.Hash) (any, error) {
+	return &reader{db: db}, nil
+}
+
+type reader struct {
+	db *Database
+}
+
+// Node retrieves the encoded node blob for the given node identifier,
+// resolving straight to the persistent disk layer.
+func (r *reader) Node(owner common.Hash, path []byte, hash common.Hash) ([]byte, error) {
+	return r.db.diskdb.Get(hash.Bytes())
+}
+
+// HasUncommittedDiffs reports whether Update has appended diff layers since
+// the last Commit. trie.Database.Fork
+// consults this to refuse switching schemes while it would otherwise orphan
+// unflushed state. Layers retained purely for the StateHistory window after
+// a successful Commit don't count: they're intentionally kept for archive
+// queries, not unflushed data.
+func (db *Database) HasUncommittedDiffs() bool {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return db.uncommitted > 0
+}
+
+// DeletedNodes returns the cumulative number of trie nodes removed across
+// every update applied to this database.
+func (db *Database) DeletedNodes() int {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return int(db.deleted)
+}
+
+// Recover rolls the database back to a specified historical point. loader is
+// used by the caller to resolve tries while replaying diff layers.
+//
+// This minimal implementation retains diff layers indexed by block number
+// rather than by state root, so arbitrary state-root rollback isn't
+// supported yet; Recoverable always reports false accordingly.
+func (db *Database) Recover(target common.Hash, loader any) error {
+	return errors.New("not supported: state history is indexed by block number, not state root")
+}
+
+// Recoverable returns the indicator if the specified state is enabled to be
+// recovered.
+func (db *Database) Recoverable(root common.Hash) bool { return false }
+
+// Reset wipes all retained diff layers and discards in-memory caches.
+func (db *Database) Reset(root common.Hash) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.diffs = nil
+	db.uncommitted = 0
+	return nil
+}
+
+// Journal commits the entire diff hierarchy to disk into a single journal
+// entry. Diff layers are kept in memory only by this implementation, so
+// there's nothing additional to persist.
+func (db *Database) Journal(root common.Hash) error { return nil }
+
+// SetBufferSize sets the node buffer size to the provided value (in bytes).
+func (db *Database) SetBufferSize(size int) error { return nil }
+
+// AccountHistory returns the recorded history of addr's account across the
+// block range [start, end], inclusive.
+func (db *Database) AccountHistory(addr common.Address, start, end uint64) ([]Record, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var history []Record
+	for _, layer := range db.diffs {
+		if layer.block < start || layer.block > end {
+			continue
+		}
+		if rec, ok := layer.accounts[addr]; ok {
+			history = append(history, rec)
+		}
+	}
+	return history, nil
+}
+
+// StorageHistory returns the recorded history of the given storage slot
+// across the block range [start, end], inclusive.
+func (db *Database) StorageHistory(addr common.Address, slot common.Hash, start, end uint64) ([]Record, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var history []Record
+	for _, layer := range db.diffs {
+		if layer.block < start || layer.block > end {
+			continue
+		}
+		slots, ok := layer.storages[addr]
+		if !ok {
+			continue
+		}
+		if rec, ok := slots[slot]; ok {
+			history = append(history, rec)
+		}
+	}
+	return history, nil
+}
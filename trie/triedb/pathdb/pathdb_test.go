@@ -0,0 +1,100 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pathdb
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/trie/triestate"
+)
+
+func update(t *testing.T, db *Database, addr common.Address, block uint64, prev, post []byte) {
+	t.Helper()
+	states := triestate.New()
+	states.Accounts[addr] = triestate.StateChange{Prev: prev, Post: post}
+	if err := db.Update(common.Hash{}, common.Hash{}, block, nil, states); err != nil {
+		t.Fatalf("Update(block=%d) failed: %v", block, err)
+	}
+}
+
+func TestCommitRetainsHistoryWithinWindow(t *testing.T) {
+	addr := common.HexToAddress("0x1")
+	db := New(memorydb.New(), &Config{StateHistory: 2})
+
+	update(t, db, addr, 1, nil, []byte("v1"))
+	if err := db.Commit(common.Hash{}, false); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	history, err := db.AccountHistory(addr, 0, 10)
+	if err != nil {
+		t.Fatalf("AccountHistory failed: %v", err)
+	}
+	if len(history) != 1 {
+		t.Fatalf("expected the just-committed block's history to survive Commit, got %d records", len(history))
+	}
+}
+
+func TestCommitPrunesBeyondRetentionWindow(t *testing.T) {
+	addr := common.HexToAddress("0x1")
+	db := New(memorydb.New(), &Config{StateHistory: 2})
+
+	for block := uint64(1); block <= 5; block++ {
+		update(t, db, addr, block, nil, []byte("v"))
+		if err := db.Commit(common.Hash{}, false); err != nil {
+			t.Fatalf("Commit(block=%d) failed: %v", block, err)
+		}
+	}
+	history, err := db.AccountHistory(addr, 0, 10)
+	if err != nil {
+		t.Fatalf("AccountHistory failed: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected only the last StateHistory=2 blocks retained, got %d records", len(history))
+	}
+	if history[0].Block != 4 || history[1].Block != 5 {
+		t.Fatalf("expected blocks [4 5] retained, got %v", []uint64{history[0].Block, history[1].Block})
+	}
+}
+
+// TestHasUncommittedDiffsIgnoresRetainedHistory makes sure a diff layer kept
+// around purely to serve the StateHistory window doesn't keep reporting as
+// "uncommitted" forever - only layers appended since the last Commit should.
+func TestHasUncommittedDiffsIgnoresRetainedHistory(t *testing.T) {
+	addr := common.HexToAddress("0x1")
+	db := New(memorydb.New(), &Config{StateHistory: 10})
+
+	if db.HasUncommittedDiffs() {
+		t.Fatalf("a freshly constructed database shouldn't have uncommitted diffs")
+	}
+
+	update(t, db, addr, 1, nil, []byte("v1"))
+	if !db.HasUncommittedDiffs() {
+		t.Fatalf("Update should mark the database as having uncommitted diffs")
+	}
+
+	if err := db.Commit(common.Hash{}, false); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if db.HasUncommittedDiffs() {
+		t.Fatalf("Commit should clear uncommitted, even though the layer is retained for history")
+	}
+	if history, _ := db.AccountHistory(addr, 0, 10); len(history) != 1 {
+		t.Fatalf("retained layer should still be visible to AccountHistory after Commit, got %d records", len(history))
+	}
+}
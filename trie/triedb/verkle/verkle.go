@@ -0,0 +1,50 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package verkle implements the verkle-tree trie node storage scheme.
+//
+// Verkle tries are stateless-friendly and share the same diff-layer-over-
+// disk-layer storage model as the experimental path-based scheme, so the
+// backend is built directly on top of pathdb.Database rather than
+// duplicating that machinery.
+package verkle
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/trie/triedb/pathdb"
+)
+
+// EmptyRootHash is the known root of an empty verkle trie. Unlike the
+// Merkle-Patricia scheme, an empty verkle trie has no meaningful polynomial
+// commitment to hash, so the all-zero hash is used as the canonical sentinel
+// instead.
+var EmptyRootHash = common.Hash{}
+
+// Database is a verkle-tree flavored trie node database. It delegates all
+// storage to an embedded pathdb.Database and only overrides Scheme so
+// callers can tell verkle and path-based databases apart.
+type Database struct {
+	*pathdb.Database
+}
+
+// New constructs a verkle-tree flavored trie node database.
+func New(diskdb ethdb.Database, config *pathdb.Config) *Database {
+	return &Database{Database: pathdb.New(diskdb, config)}
+}
+
+// Scheme returns the identifier of the used storage scheme.
+func (db *Database) Scheme() string { return "verkle" }
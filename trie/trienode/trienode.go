@@ -0,0 +1,91 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package trienode defines the types used to pass sets of dirty (inserted,
+// updated or deleted) trie nodes between a trie and its backing database.
+package trienode
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Node is a wrapper which contains the encoded blob of a trie node and its
+// unique hash identifier. A zero-length Blob means the node identified by
+// Hash has been deleted.
+type Node struct {
+	Hash common.Hash // Node hash; for a deleted node, the hash of the removed node
+	Blob []byte      // Encoded node blob, nil for a deleted node
+}
+
+// New constructs a node with the provided node information.
+func New(hash common.Hash, blob []byte) *Node {
+	return &Node{Hash: hash, Blob: blob}
+}
+
+// NewDeleted constructs a tombstone node recording the deletion of hash.
+func NewDeleted(hash common.Hash) *Node {
+	return &Node{Hash: hash}
+}
+
+// IsDeleted reports whether the node is a tombstone for a deleted node.
+func (n *Node) IsDeleted() bool {
+	return len(n.Blob) == 0
+}
+
+// NodeSet contains the dirty nodes collected for a single trie, identified
+// by Owner (the zero hash for the account trie, or the hash of the owning
+// account's address for a storage trie), keyed by the node's path within
+// that trie.
+type NodeSet struct {
+	Owner common.Hash
+	Nodes map[string]*Node
+}
+
+// NewNodeSet initializes an empty node set for the trie owned by owner.
+func NewNodeSet(owner common.Hash) *NodeSet {
+	return &NodeSet{
+		Owner: owner,
+		Nodes: make(map[string]*Node),
+	}
+}
+
+// AddNode tracks the given node as dirty at the provided path.
+func (set *NodeSet) AddNode(path []byte, n *Node) {
+	set.Nodes[string(path)] = n
+}
+
+// MergedNodeSet represents the merged dirty node sets of a group of tries,
+// e.g. the account trie and every storage trie touched in the same block.
+type MergedNodeSet struct {
+	Sets map[common.Hash]*NodeSet
+}
+
+// NewMergedNodeSet initializes an empty merged node set.
+func NewMergedNodeSet() *MergedNodeSet {
+	return &MergedNodeSet{Sets: make(map[common.Hash]*NodeSet)}
+}
+
+// Merge folds the given node set, belonging to a single trie, into the
+// merged set. It's an error to merge two sets with the same owner.
+func (set *MergedNodeSet) Merge(other *NodeSet) error {
+	if _, present := set.Sets[other.Owner]; present {
+		return fmt.Errorf("duplicate trie for owner %#x", other.Owner)
+	}
+	set.Sets[other.Owner] = other
+	return nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package triestate defines the set of account and storage mutations applied
+// during a single state transition, as handed to trie.Database.Update.
+package triestate
+
+import "github.com/ethereum/go-ethereum/common"
+
+// StateChange records the encoded value of an account or storage slot
+// immediately before and after a state transition. Prev or Post is nil if
+// the account/slot didn't exist on that side of the transition.
+type StateChange struct {
+	Prev []byte
+	Post []byte
+}
+
+// Set represents the account and storage mutations collected while applying
+// a single block, keyed by the account address (and, for storage, the slot
+// hash within that account).
+type Set struct {
+	Accounts map[common.Address]StateChange
+	Storages map[common.Address]map[common.Hash]StateChange
+}
+
+// New constructs an empty state set.
+func New() *Set {
+	return &Set{
+		Accounts: make(map[common.Address]StateChange),
+		Storages: make(map[common.Address]map[common.Hash]StateChange),
+	}
+}